@@ -2,10 +2,18 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"embed"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
 	"net"
@@ -13,10 +21,16 @@ import (
 	"net/netip"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/LaFibre-info/ipv6domains/pkg/dnscache"
 )
 
 //go:embed web/*
@@ -33,39 +47,867 @@ type Result struct {
 	MX6      []string
 	WWW4     []string
 	WWW6     []string
+
+	SPFNetworks  []string // flattened ip4:/ip6: networks and a/mx resolved addresses
+	SPFHasIP6    bool     // at least one permitted sender network is IPv6
+	SPFAllIP6    bool     // every permitted sender network is IPv6
+	DMARCPresent bool
+	DKIMPresent  bool // TXT record found at dkimSelector._domainkey.<domain>
+
+	SRV map[string]SRVResult // keyed by "service/proto", e.g. "xmpp-server/tcp"
+
+	Reach6 map[string]ProbeResult // keyed by "<category>:<address>", e.g. "www:2001:db8::1"
 }
 
-// func customResolver() {
-// 	r := &net.Resolver{
-// 		PreferGo: true,
-// 		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-// 			d := net.Dialer{
-// 				Timeout: time.Millisecond * time.Duration(10000),
-// 			}
-// 			return d.DialContext(ctx, network, "8.8.8.8:53")
-// 		},
-// 	}
-// 	ip, _ := r.LookupHost(context.Background(), "www.google.com")
-// }
-
-// QueryHost performs net.LookupHost on a host name and return the responses in distinct IPv4 and IPv6 lists.
-func QueryHost(host string) (ipv4 []string, ipv6 []string, err error) {
-	//var a4, a6 []string
+// ProbeResult records the outcome of actively probing an IPv6 address,
+// rather than just trusting the presence of an AAAA record.
+type ProbeResult struct {
+	Dial       bool          `json:"dial"`        // TCP (or best-effort UDP) connect succeeded
+	TLS        string        `json:"tls"`         // negotiated ALPN protocol, if a TLS handshake succeeded
+	HTTPStatus int           `json:"http_status"` // HTTP status code, if an HTTP request got a response
+	RTT        time.Duration `json:"-"`           // time to the first successful connect; see RTTMillis for the API view
+}
+
+// SRVResult holds the targets published by a SRV record and the addresses
+// they resolve to.
+type SRVResult struct {
+	Targets []string `json:"targets"`
+	V4      []string `json:"v4"`
+	V6      []string `json:"v6"`
+}
+
+// Resolver wraps a *net.Resolver configured to query a fixed set of upstream
+// DNS servers instead of the host's stub resolver. This lets a machine
+// without IPv6 transit to its configured system resolver still get accurate
+// results by pointing at a known-good IPv6-capable server (e.g.
+// 2606:4700:4700::1111).
+type Resolver struct {
+	r       *net.Resolver
+	servers []string // host:port, tried in rotation
+	netType string   // "udp", "tcp", "doh" or "dot"; empty for the system resolver
+	next    uint32   // atomic round-robin counter over servers
+}
+
+// newResolver builds a Resolver that rotates across servers (if any) using
+// netType ("udp", "tcp", "doh" or "dot"). An empty servers list falls back to
+// the system's normal resolution behaviour.
+func newResolver(servers []string, preferGo bool, netType string) *Resolver {
+	res := &Resolver{servers: servers, netType: netType}
+
+	if len(servers) == 0 {
+		res.r = &net.Resolver{PreferGo: preferGo}
+		return res
+	}
+
+	switch netType {
+	case "doh":
+		// DoH (RFC 8484) is HTTP-framed, not the classic wire protocol that
+		// net.Resolver's Dial hook wraps, so it can't be plugged in via
+		// Dial at all. LookupHost/NS/MX/TXT/SRV special-case netType=="doh"
+		// and go through dohQuery instead; res.r is left as a harmless,
+		// unused fallback.
+		res.r = &net.Resolver{PreferGo: true}
+	default:
+		res.r = &net.Resolver{
+			PreferGo: true,
+			Dial:     res.dial(netType),
+		}
+	}
+	return res
+}
+
+// pickServer returns the next configured upstream server, round-robin.
+func (res *Resolver) pickServer() string {
+	i := atomic.AddUint32(&res.next, 1) - 1
+	addr := res.servers[i%uint32(len(res.servers))]
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		// no port given (bare host or IPv6 literal): default to 53
+		addr = net.JoinHostPort(addr, "53")
+	}
+	return addr
+}
+
+// dial builds a net.Resolver.Dial func that connects to the next upstream
+// server over plain UDP/TCP, or over TLS for DNS-over-TLS (RFC 7858), whose
+// wire framing is identical to TCP DNS.
+func (res *Resolver) dial(netType string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := net.Dialer{Timeout: 10 * time.Second}
+		addr := res.pickServer()
+
+		switch netType {
+		case "dot":
+			addr2 := addr
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				addr2 = net.JoinHostPort(host, "853")
+			}
+			host, _, _ := net.SplitHostPort(addr2)
+			tlsDialer := tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host}}
+			return tlsDialer.DialContext(ctx, "tcp", addr2)
+		case "tcp":
+			return d.DialContext(ctx, "tcp", addr)
+		default: // "udp"
+			// Honor what net.Resolver actually asks for here, not just the
+			// configured netType: per RFC 5966 it retries a truncated UDP
+			// answer by calling us again with network=="tcp", and redialing
+			// UDP in that case would just repeat the same truncated answer.
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+}
+
+// DNS record types used by the hand-rolled DoH codec below. Only the
+// handful this package actually looks up.
+const (
+	dnsTypeA    = 1
+	dnsTypeNS   = 2
+	dnsTypeMX   = 15
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsClassIN  = 1
+)
+
+// RFC 1035 RCODEs this package distinguishes; every other nonzero value is
+// treated as a generic hard failure.
+const (
+	dnsRcodeNoError  = 0
+	dnsRcodeNXDomain = 3
+)
+
+// encodeDNSQuery builds a minimal RFC 1035 query message (one question,
+// recursion desired, ID left as 0 since DoH is a single request/response
+// over HTTP with no multiplexing to disambiguate).
+func encodeDNSQuery(name string, qtype uint16) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+	var tail [4]byte
+	binary.BigEndian.PutUint16(tail[0:2], qtype)
+	binary.BigEndian.PutUint16(tail[2:4], dnsClassIN)
+	buf.Write(tail[:])
+	return buf.Bytes()
+}
+
+// dnsRR is a decoded resource record. RData is the raw rdata bytes; for
+// record types whose rdata embeds a (possibly compressed) domain name,
+// RDataOffset gives that rdata's absolute offset in Msg so the name can be
+// decoded with the full message available for compression pointers.
+type dnsRR struct {
+	Type        uint16
+	RData       []byte
+	Msg         []byte
+	RDataOffset int
+}
+
+// decodeDNSMessage parses an RFC 1035 message and returns its answer
+// records. name is the queried name, used only to annotate a *net.DNSError
+// if the RCODE reports failure. A nonzero RCODE (SERVFAIL, REFUSED,
+// FORMERR, ...) is rejected here rather than left to fall through as a
+// zero-answer success, which would otherwise be indistinguishable from
+// legitimate NODATA; NXDOMAIN maps to an error isNotfound recognizes.
+func decodeDNSMessage(data []byte, name string) ([]dnsRR, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("dns message too short")
+	}
+	if rcode := int(data[3] & 0x0f); rcode != dnsRcodeNoError {
+		dnserr := &net.DNSError{Err: fmt.Sprintf("dns: server returned rcode %d", rcode), Name: name}
+		if rcode == dnsRcodeNXDomain {
+			dnserr.Err = "no such host"
+			dnserr.IsNotFound = true
+		}
+		return nil, dnserr
+	}
+	qdcount := int(binary.BigEndian.Uint16(data[4:6]))
+	ancount := int(binary.BigEndian.Uint16(data[6:8]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := decodeDNSName(data, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4 // qtype + qclass
+	}
+
+	rrs := make([]dnsRR, 0, ancount)
+	for i := 0; i < ancount; i++ {
+		_, next, err := decodeDNSName(data, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		if off+10 > len(data) {
+			return nil, fmt.Errorf("truncated resource record")
+		}
+		typ := binary.BigEndian.Uint16(data[off : off+2])
+		rdlen := int(binary.BigEndian.Uint16(data[off+8 : off+10]))
+		off += 10
+		if off+rdlen > len(data) {
+			return nil, fmt.Errorf("truncated rdata")
+		}
+		rrs = append(rrs, dnsRR{Type: typ, RData: data[off : off+rdlen], Msg: data, RDataOffset: off})
+		off += rdlen
+	}
+	return rrs, nil
+}
 
-	addrs, err := net.LookupHost(host)
+// decodeDNSName decodes a (possibly compressed) domain name starting at
+// off, returning the name and the offset just past it in the uncompressed
+// stream (i.e. past the pointer, not into its target).
+func decodeDNSName(data []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for i := 0; i < 128; i++ { // bounds a malicious/garbled pointer chain
+		if off >= len(data) {
+			return "", 0, fmt.Errorf("dns name out of range")
+		}
+		l := int(data[off])
+		switch {
+		case l == 0:
+			if end == -1 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(data) {
+				return "", 0, fmt.Errorf("truncated dns name pointer")
+			}
+			if end == -1 {
+				end = off + 2
+			}
+			off = (l&0x3f)<<8 | int(data[off+1])
+		default:
+			off++
+			if off+l > len(data) {
+				return "", 0, fmt.Errorf("truncated dns label")
+			}
+			labels = append(labels, string(data[off:off+l]))
+			off += l
+		}
+	}
+	return "", 0, fmt.Errorf("dns name too long or pointer loop")
+}
+
+// dohQuery issues name/qtype as an RFC 8484 DNS-over-HTTPS request (an
+// HTTP POST of the wire-format query with an application/dns-message
+// content type) against the next configured upstream server, and decodes
+// the answer records from the response body.
+func (res *Resolver) dohQuery(ctx context.Context, name string, qtype uint16) ([]dnsRR, error) {
+	server := res.pickServer()
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	url := "https://" + host + "/dns-query"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(encodeDNSQuery(name, qtype)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh query to %s failed: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDNSMessage(body, name)
+}
+
+// isDoH reports whether this resolver must go through dohQuery rather than
+// res.r (i.e. -net doh with at least one -resolver server configured).
+func (res *Resolver) isDoH() bool {
+	return res.netType == "doh" && len(res.servers) > 0
+}
+
+// LookupHost resolves host using this resolver's configured upstream servers.
+func (res *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if !res.isDoH() {
+		return res.r.LookupHost(ctx, host)
+	}
+	var addrs []string
+	for _, qtype := range [...]uint16{dnsTypeA, dnsTypeAAAA} {
+		rrs, err := res.dohQuery(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range rrs {
+			switch {
+			case rr.Type == dnsTypeA && len(rr.RData) == 4:
+				var a4 [4]byte
+				copy(a4[:], rr.RData)
+				addrs = append(addrs, netip.AddrFrom4(a4).String())
+			case rr.Type == dnsTypeAAAA && len(rr.RData) == 16:
+				var a16 [16]byte
+				copy(a16[:], rr.RData)
+				addrs = append(addrs, netip.AddrFrom16(a16).String())
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// LookupNS resolves the NS records for name using this resolver.
+func (res *Resolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	if !res.isDoH() {
+		return res.r.LookupNS(ctx, name)
+	}
+	rrs, err := res.dohQuery(ctx, name, dnsTypeNS)
+	if err != nil {
+		return nil, err
+	}
+	var nss []*net.NS
+	for _, rr := range rrs {
+		if rr.Type != dnsTypeNS {
+			continue
+		}
+		host, _, err := decodeDNSName(rr.Msg, rr.RDataOffset)
+		if err != nil {
+			return nil, err
+		}
+		nss = append(nss, &net.NS{Host: host + "."})
+	}
+	return nss, nil
+}
+
+// LookupMX resolves the MX records for name using this resolver.
+func (res *Resolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if !res.isDoH() {
+		return res.r.LookupMX(ctx, name)
+	}
+	rrs, err := res.dohQuery(ctx, name, dnsTypeMX)
+	if err != nil {
+		return nil, err
+	}
+	var mxs []*net.MX
+	for _, rr := range rrs {
+		if rr.Type != dnsTypeMX || len(rr.RData) < 2 {
+			continue
+		}
+		pref := binary.BigEndian.Uint16(rr.RData[:2])
+		host, _, err := decodeDNSName(rr.Msg, rr.RDataOffset+2)
+		if err != nil {
+			return nil, err
+		}
+		mxs = append(mxs, &net.MX{Host: host + ".", Pref: pref})
+	}
+	return mxs, nil
+}
+
+// LookupTXT resolves the TXT records for name using this resolver.
+func (res *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if !res.isDoH() {
+		return res.r.LookupTXT(ctx, name)
+	}
+	rrs, err := res.dohQuery(ctx, name, dnsTypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txts []string
+	for _, rr := range rrs {
+		if rr.Type != dnsTypeTXT {
+			continue
+		}
+		var sb strings.Builder
+		for i := 0; i < len(rr.RData); {
+			n := int(rr.RData[i])
+			i++
+			if i+n > len(rr.RData) {
+				break
+			}
+			sb.Write(rr.RData[i : i+n])
+			i += n
+		}
+		txts = append(txts, sb.String())
+	}
+	return txts, nil
+}
+
+// LookupSRV resolves the SRV records for _service._proto.name using this
+// resolver.
+func (res *Resolver) LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, error) {
+	if !res.isDoH() {
+		_, addrs, err := res.r.LookupSRV(ctx, service, proto, name)
+		return addrs, err
+	}
+	qname := fmt.Sprintf("_%s._%s.%s", service, proto, name)
+	rrs, err := res.dohQuery(ctx, qname, dnsTypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	var srvs []*net.SRV
+	for _, rr := range rrs {
+		if rr.Type != dnsTypeSRV || len(rr.RData) < 6 {
+			continue
+		}
+		target, _, err := decodeDNSName(rr.Msg, rr.RDataOffset+6)
+		if err != nil {
+			return nil, err
+		}
+		srvs = append(srvs, &net.SRV{
+			Priority: binary.BigEndian.Uint16(rr.RData[0:2]),
+			Weight:   binary.BigEndian.Uint16(rr.RData[2:4]),
+			Port:     binary.BigEndian.Uint16(rr.RData[4:6]),
+			Target:   target + ".",
+		})
+	}
+	return srvs, nil
+}
+
+// resolver is the process-wide Resolver, configured from flags in main()
+// before any lookups happen. It defaults to the system resolver.
+var resolver = newResolver(nil, false, "udp")
+
+// lookupCache caches QueryHost/LookupNS/LookupMX results so that batch runs
+// over lists with overlapping parent domains (shared NS or MX) don't
+// re-resolve the same records for every domain. It is nil when -no-cache is
+// set, in which case dnscache.GetOrLoad degrades to a plain passthrough.
+var lookupCache *dnscache.Cache
+
+// hostCacheTTL, nsCacheTTL, mxCacheTTL and txtCacheTTL are the TTLs applied
+// to cached host, NS, MX and TXT lookups respectively, configured from the
+// -cache-ttl-host/-ns/-mx/-txt flags in main(). Record types are cached
+// separately because they change on different timescales in practice: NS
+// delegations are close to static, while TXT records (SPF/DKIM/DMARC, often
+// including short-lived verification tokens) churn much faster.
+var (
+	hostCacheTTL = 15 * time.Minute
+	nsCacheTTL   = time.Hour
+	mxCacheTTL   = 30 * time.Minute
+	txtCacheTTL  = 5 * time.Minute
+)
+
+// lookupConcurrency bounds how many of QueryDomain's independent
+// sub-lookups (host, www, NS, MX, SPF, DMARC, DKIM, SRV) run at once,
+// configured from the -lookup-concurrency flag in main().
+var lookupConcurrency = 8
+
+// hostResult is the cached value type for QueryHost lookups.
+type hostResult struct {
+	v4, v6 []string
+}
+
+// resolverList is a flag.Value collecting repeated -resolver flags in order.
+type resolverList []string
+
+func (l *resolverList) String() string { return strings.Join(*l, ",") }
+func (l *resolverList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// QueryHost performs a host lookup (via the configured resolver, through
+// lookupCache) and returns the responses in distinct IPv4 and IPv6 lists.
+func QueryHost(host string) (ipv4 []string, ipv6 []string, err error) {
+	res, err := dnscache.GetOrLoad(lookupCache, "host:"+host, hostCacheTTL, func() (hostResult, error) {
+		addrs, err := resolver.LookupHost(context.Background(), host)
+		if err != nil {
+			return hostResult{}, err
+		}
+		var hr hostResult
+		for _, h := range addrs {
+			a, _ := netip.ParseAddr(h)
+			if a.Is4() {
+				hr.v4 = append(hr.v4, a.String())
+			}
+			if a.Is6() {
+				hr.v6 = append(hr.v6, a.String())
+			}
+		}
+		return hr, nil
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-	for _, h := range addrs {
-		a, _ := netip.ParseAddr(h)
-		if a.Is4() {
-			ipv4 = append(ipv4, a.String())
+	return res.v4, res.v6, nil
+}
+
+// dkimSelector is the DKIM selector checked for DKIMPresent, configurable
+// via the -dkim-selector flag.
+var dkimSelector = "default"
+
+// spfLookupLimit is the RFC 7208 cap on the number of DNS lookups an SPF
+// evaluation may perform (include/redirect/a/mx/exists/ptr combined; here we
+// only count include/redirect/a/mx since that's all TraverseSPF follows).
+const spfLookupLimit = 10
+
+// lookupTXT resolves the TXT records for name, through lookupCache.
+func lookupTXT(name string) ([]string, error) {
+	return dnscache.GetOrLoad(lookupCache, "txt:"+name, txtCacheTTL, func() ([]string, error) {
+		return resolver.LookupTXT(context.Background(), name)
+	})
+}
+
+// lookupTXTFn is the TXT lookup TraverseSPF walks through; overridden in
+// tests to exercise its loop detection and lookup-limit logic without a
+// live resolver.
+var lookupTXTFn = lookupTXT
+
+// TraverseSPF recursively expands the SPF record of domain (and any
+// include:/redirect= it references) into a flat list of permitted sender
+// networks (ip4:/ip6: CIDRs, plus addresses resolved from a/mx mechanisms).
+// It enforces the RFC 7208 limit of 10 DNS-lookup-consuming mechanisms and
+// guards against include loops with a visited set.
+func TraverseSPF(domain string) (networks []string, err error) {
+	visited := make(map[string]bool)
+	lookups := 0
+
+	var walk func(d string) error
+	walk = func(d string) error {
+		if visited[d] {
+			return fmt.Errorf("SPF include loop detected at %s", d)
+		}
+		// Only the current ancestor chain (A -> B -> A) is a loop; two
+		// independent includes that both reach the same shared fragment
+		// (a "diamond") are legitimate and must each be allowed to expand
+		// it, so unmark d once this branch of the walk returns.
+		visited[d] = true
+		defer delete(visited, d)
+
+		txts, err := lookupTXTFn(d)
+		if err != nil && !isNotfound(err) {
+			return fmt.Errorf("LookupTXT failed: %v", err)
+		}
+
+		var spf string
+		for _, t := range txts {
+			if strings.HasPrefix(t, "v=spf1") {
+				spf = t
+				break
+			}
+		}
+		if spf == "" {
+			return nil
+		}
+
+		for _, field := range strings.Fields(spf)[1:] {
+			// strip the qualifier (+pass, ~softfail, -fail, ?neutral); default is +
+			mechanism := strings.TrimLeft(field, "+-~?")
+
+			switch {
+			case strings.HasPrefix(mechanism, "ip4:"):
+				networks = append(networks, strings.TrimPrefix(mechanism, "ip4:"))
+			case strings.HasPrefix(mechanism, "ip6:"):
+				networks = append(networks, strings.TrimPrefix(mechanism, "ip6:"))
+			case mechanism == "mx" || strings.HasPrefix(mechanism, "mx:") || strings.HasPrefix(mechanism, "mx/"):
+				lookups++
+				if lookups > spfLookupLimit {
+					return fmt.Errorf("SPF lookup limit (%d) exceeded", spfLookupLimit)
+				}
+				target := strings.TrimPrefix(strings.SplitN(mechanism, "/", 2)[0], "mx:")
+				if target == "mx" {
+					target = d
+				}
+				mxs, err := resolver.LookupMX(context.Background(), target)
+				if err != nil && !isNotfound(err) {
+					return fmt.Errorf("LookupMX failed: %v", err)
+				}
+				for _, mx := range mxs {
+					v4, v6, err := QueryHost(mx.Host)
+					if err != nil && !isNotfound(err) {
+						return fmt.Errorf("QueryHost failed: %v", err)
+					}
+					networks = append(networks, appendHostNetworks(v4, v6)...)
+				}
+			case mechanism == "a" || strings.HasPrefix(mechanism, "a:") || strings.HasPrefix(mechanism, "a/"):
+				lookups++
+				if lookups > spfLookupLimit {
+					return fmt.Errorf("SPF lookup limit (%d) exceeded", spfLookupLimit)
+				}
+				target := strings.TrimPrefix(strings.SplitN(mechanism, "/", 2)[0], "a:")
+				if target == "a" {
+					target = d
+				}
+				v4, v6, err := QueryHost(target)
+				if err != nil && !isNotfound(err) {
+					return fmt.Errorf("QueryHost failed: %v", err)
+				}
+				networks = append(networks, appendHostNetworks(v4, v6)...)
+			case strings.HasPrefix(mechanism, "include:"):
+				lookups++
+				if lookups > spfLookupLimit {
+					return fmt.Errorf("SPF lookup limit (%d) exceeded", spfLookupLimit)
+				}
+				if err := walk(strings.TrimPrefix(mechanism, "include:")); err != nil {
+					return err
+				}
+			case strings.HasPrefix(mechanism, "redirect="):
+				lookups++
+				if lookups > spfLookupLimit {
+					return fmt.Errorf("SPF lookup limit (%d) exceeded", spfLookupLimit)
+				}
+				if err := walk(strings.TrimPrefix(mechanism, "redirect=")); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err = walk(domain)
+	return networks, err
+}
+
+// classifySPFNetworks reports whether any/all of the given ip4:/ip6:
+// networks (as produced by TraverseSPF) are IPv6.
+func classifySPFNetworks(networks []string) (hasIP6, allIP6 bool) {
+	if len(networks) == 0 {
+		return false, false
+	}
+	allIP6 = true
+	for _, n := range networks {
+		p, err := netip.ParsePrefix(n)
+		if err != nil {
+			a, aerr := netip.ParseAddr(n)
+			if aerr != nil {
+				allIP6 = false
+				continue
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
 		}
-		if a.Is6() {
-			ipv6 = append(ipv6, a.String())
+		if p.Addr().Is6() && !p.Addr().Is4In6() {
+			hasIP6 = true
+		} else {
+			allIP6 = false
+		}
+	}
+	return hasIP6, allIP6
+}
+
+// appendHostNetworks turns resolved A/AAAA addresses into host networks
+// (/32 and /128) suitable for inclusion in an SPF network list.
+func appendHostNetworks(v4, v6 []string) (networks []string) {
+	for _, a := range v4 {
+		networks = append(networks, a+"/32")
+	}
+	for _, a := range v6 {
+		networks = append(networks, a+"/128")
+	}
+	return networks
+}
+
+// hasDMARC reports whether domain publishes a _dmarc TXT record.
+func hasDMARC(domain string) (bool, error) {
+	txts, err := lookupTXT("_dmarc." + domain)
+	if err != nil {
+		if isNotfound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, t := range txts {
+		if strings.HasPrefix(t, "v=DMARC1") {
+			return true, nil
 		}
 	}
-	return ipv4, ipv6, nil
+	return false, nil
+}
+
+// defaultSRVServices is the curated set of "service/proto" pairs probed when
+// -srv is not given, covering the service-discovery records people most
+// often care about for IPv6 readiness.
+var defaultSRVServices = []string{
+	"xmpp-server/tcp",
+	"ldap/tcp",
+	"sip/udp",
+	"matrix/tcp",
+	"autodiscover/tcp",
+	"caldav/tcp",
+	"carddav/tcp",
+}
+
+// srvServices is the process-wide list of "service/proto" pairs to probe,
+// configured from the -srv flag in main().
+var srvServices = defaultSRVServices
+
+// parseSRVServices parses a comma-separated "service/proto,..." flag value.
+func parseSRVServices(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var services []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if _, _, ok := strings.Cut(part, "/"); !ok {
+			return nil, fmt.Errorf("invalid -srv entry %q: want service/proto", part)
+		}
+		services = append(services, part)
+	}
+	return services, nil
+}
+
+// QuerySRV resolves the given "service/proto" SRV records under
+// domain (e.g. _xmpp-server._tcp.domain) and resolves each target's
+// addresses, for services such as XMPP federation, LDAP, SIP or Matrix that
+// are published via SRV rather than A/AAAA/MX.
+func QuerySRV(domain string, services []string) (map[string]SRVResult, error) {
+	results := make(map[string]SRVResult)
+	for _, svc := range services {
+		service, proto, _ := strings.Cut(svc, "/")
+		srvs, err := resolver.LookupSRV(context.Background(), service, proto, domain)
+		if err != nil {
+			if isNotfound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("LookupSRV(%s) failed: %v", svc, err)
+		}
+		var res SRVResult
+		for _, s := range srvs {
+			res.Targets = append(res.Targets, s.Target)
+			v4, v6, err := QueryHost(s.Target)
+			if err != nil && !isNotfound(err) {
+				return nil, fmt.Errorf("QueryHost for SRV target failed: %v", err)
+			}
+			res.V4 = append(res.V4, v4...)
+			res.V6 = append(res.V6, v6...)
+		}
+		if len(res.Targets) > 0 {
+			results[svc] = res
+		}
+	}
+	return results, nil
+}
+
+// probeEnabled turns on active connectivity probing, configured from the
+// -probe flag in main(). Rank only counts AAAA presence unless this is set,
+// since a domain can advertise AAAA records that all refuse connections.
+var probeEnabled bool
+
+// probeTimeout bounds each dial/handshake/HTTP attempt, configured from the
+// -probe-timeout flag in main().
+var probeTimeout = 3 * time.Second
+
+var (
+	wwwProbePorts = []int{443, 80}
+	mxProbePorts  = []int{25, 587}
+	nsProbePorts  = []int{53}
+)
+
+// ProbeReachability actively probes every discovered IPv6 address in r
+// (www/apex host, NS, MX) to check it is actually reachable over IPv6, not
+// just present in an AAAA record.
+func ProbeReachability(r *Result) map[string]ProbeResult {
+	results := make(map[string]ProbeResult)
+	for _, addr := range r.WWW6 {
+		results["www:"+addr] = probeAddr(addr, wwwProbePorts, "www."+r.Domain)
+	}
+	for _, addr := range r.Host6 {
+		results["host:"+addr] = probeAddr(addr, wwwProbePorts, r.Domain)
+	}
+	for _, addr := range r.MX6 {
+		results["mx:"+addr] = probeAddr(addr, mxProbePorts, "")
+	}
+	for _, addr := range r.NS6 {
+		results["ns:"+addr] = probeAddrUDPAndTCP(addr, nsProbePorts)
+	}
+	return results
+}
+
+// probeAddr dials addr on the first of ports that accepts a connection. For
+// port 443 it additionally performs a TLS handshake with sniHost as the SNI,
+// and for 443/80 it issues a plain HTTP GET (over TLS for 443) to record the
+// HTTP status, using sniHost as the Host header.
+func probeAddr(addr string, ports []int, sniHost string) (res ProbeResult) {
+	for _, port := range ports {
+		start := time.Now()
+		conn, err := (&net.Dialer{Timeout: probeTimeout}).Dial("tcp6", net.JoinHostPort(addr, strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		res.Dial = true
+		res.RTT = time.Since(start)
+
+		if port == 443 {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: sniHost})
+			tlsConn.SetDeadline(time.Now().Add(probeTimeout))
+			if err := tlsConn.Handshake(); err == nil {
+				res.TLS = tlsConn.ConnectionState().NegotiatedProtocol
+				if sniHost != "" {
+					res.HTTPStatus, _ = httpStatusOverConn(tlsConn, sniHost)
+				}
+			}
+			tlsConn.Close()
+		} else if port == 80 && sniHost != "" {
+			res.HTTPStatus, _ = httpStatusOverConn(conn, sniHost)
+			conn.Close()
+		} else {
+			conn.Close()
+		}
+		break
+	}
+	return res
+}
+
+// probeAddrUDPAndTCP is used for DNS servers: it dials each port over both
+// UDP and TCP and reports success if either answers. UDP "dial" only builds
+// a local socket (DNS is connectionless), so it is a much weaker signal than
+// the TCP probe; it is kept best-effort and documented as such.
+func probeAddrUDPAndTCP(addr string, ports []int) (res ProbeResult) {
+	for _, port := range ports {
+		start := time.Now()
+		if conn, err := (&net.Dialer{Timeout: probeTimeout}).Dial("tcp6", net.JoinHostPort(addr, strconv.Itoa(port))); err == nil {
+			res.Dial = true
+			res.RTT = time.Since(start)
+			conn.Close()
+			break
+		}
+		if conn, err := (&net.Dialer{Timeout: probeTimeout}).Dial("udp6", net.JoinHostPort(addr, strconv.Itoa(port))); err == nil {
+			res.Dial = true
+			res.RTT = time.Since(start)
+			conn.Close()
+			break
+		}
+	}
+	return res
+}
+
+// httpStatusOverConn issues a single HTTP/1.1 GET over an already-dialed
+// conn and returns the response status code.
+func httpStatusOverConn(conn net.Conn, host string) (int, error) {
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Host = host
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+	if err := req.Write(conn); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// hasDKIM reports whether domain publishes a DKIM TXT record for selector.
+func hasDKIM(domain, selector string) (bool, error) {
+	txts, err := lookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		if isNotfound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(txts) > 0, nil
 }
 
 // check if given error is the DNS IsNotFound error
@@ -82,47 +924,142 @@ func QueryDomain(domain string) (*Result, error) {
 
 	domain = strings.TrimPrefix(domain, "www.")
 	var r Result = Result{Domain: domain}
-	var err error
-	// hosts
-	r.Host4, r.Host6, err = QueryHost(domain)
-	if err != nil && !isNotfound(err) {
-		return nil, fmt.Errorf("QueryHost failed: %v", err)
-	}
-	// wwww hosts
-	r.WWW4, r.WWW6, err = QueryHost("www." + domain)
-	if err != nil && !isNotfound(err) {
-		return nil, fmt.Errorf("QueryHost (www) failed: %v", err)
-	}
-	// NS
-	nss, err := net.LookupNS(domain)
-	if err != nil && !isNotfound(err) {
-		return nil, fmt.Errorf("LookupNS failed: %v", err)
-	}
-	// no NS at , nor IPv4 nor IPv6 (shouldn't happen)
-	if len(nss) == 0 {
-		return nil, fmt.Errorf("LookupNS failed: domain has no NS")
-	}
-	for _, ns := range nss {
-		ns4, ns6, err := QueryHost(ns.Host)
-		if err != nil {
-			return nil, fmt.Errorf("QueryHost for NS failed: %v", err)
+
+	// The sub-lookups below are independent of one another (they each fill
+	// in disjoint fields of r), so fan them out instead of paying the sum of
+	// their DNS RTTs. Each reports its failure through errs rather than
+	// returning it, so errors.Join can aggregate every failure instead of
+	// the first one masking the rest (e.g. a failed MX lookup no longer
+	// hides a real failure on NS).
+	var errsMu sync.Mutex
+	var errs []error
+	addErr := func(err error) {
+		if err == nil {
+			return
 		}
-		r.NS4 = append(r.NS4, ns4...)
-		r.NS6 = append(r.NS6, ns6...)
-	}
-	// MX
-	mxs, err := net.LookupMX(domain)
-	if err != nil && !isNotfound(err) {
-		return nil, fmt.Errorf("LookupMX failed: %v", err)
+		errsMu.Lock()
+		errs = append(errs, err)
+		errsMu.Unlock()
 	}
-	for _, mx := range mxs {
-		mx4, mx6, err := QueryHost(mx.Host)
+
+	var g errgroup.Group
+	g.SetLimit(lookupConcurrency)
+
+	g.Go(func() error {
+		v4, v6, err := QueryHost(domain)
 		if err != nil && !isNotfound(err) {
-			return nil, fmt.Errorf("QueryHost for MX failed: %v", err)
-		} else {
+			addErr(fmt.Errorf("QueryHost failed: %v", err))
+			return nil
+		}
+		r.Host4, r.Host6 = v4, v6
+		return nil
+	})
+
+	g.Go(func() error {
+		v4, v6, err := QueryHost("www." + domain)
+		if err != nil && !isNotfound(err) {
+			addErr(fmt.Errorf("QueryHost (www) failed: %v", err))
+			return nil
+		}
+		r.WWW4, r.WWW6 = v4, v6
+		return nil
+	})
+
+	g.Go(func() error {
+		nss, err := dnscache.GetOrLoad(lookupCache, "ns:"+domain, nsCacheTTL, func() ([]*net.NS, error) {
+			return resolver.LookupNS(context.Background(), domain)
+		})
+		if err != nil && !isNotfound(err) {
+			addErr(fmt.Errorf("LookupNS failed: %v", err))
+			return nil
+		}
+		// no NS at , nor IPv4 nor IPv6 (shouldn't happen)
+		if len(nss) == 0 {
+			addErr(fmt.Errorf("LookupNS failed: domain has no NS"))
+			return nil
+		}
+		for _, ns := range nss {
+			ns4, ns6, err := QueryHost(ns.Host)
+			if err != nil {
+				addErr(fmt.Errorf("QueryHost for NS failed: %v", err))
+				return nil
+			}
+			r.NS4 = append(r.NS4, ns4...)
+			r.NS6 = append(r.NS6, ns6...)
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		mxs, err := dnscache.GetOrLoad(lookupCache, "mx:"+domain, mxCacheTTL, func() ([]*net.MX, error) {
+			return resolver.LookupMX(context.Background(), domain)
+		})
+		if err != nil && !isNotfound(err) {
+			addErr(fmt.Errorf("LookupMX failed: %v", err))
+			return nil
+		}
+		for _, mx := range mxs {
+			mx4, mx6, err := QueryHost(mx.Host)
+			if err != nil && !isNotfound(err) {
+				addErr(fmt.Errorf("QueryHost for MX failed: %v", err))
+				return nil
+			}
 			r.MX4 = append(r.MX4, mx4...)
 			r.MX6 = append(r.MX6, mx6...)
 		}
+		return nil
+	})
+
+	g.Go(func() error {
+		networks, err := TraverseSPF(domain)
+		if err != nil {
+			addErr(fmt.Errorf("TraverseSPF failed: %v", err))
+			return nil
+		}
+		r.SPFNetworks = networks
+		r.SPFHasIP6, r.SPFAllIP6 = classifySPFNetworks(networks)
+		return nil
+	})
+
+	g.Go(func() error {
+		present, err := hasDMARC(domain)
+		if err != nil {
+			addErr(fmt.Errorf("hasDMARC failed: %v", err))
+			return nil
+		}
+		r.DMARCPresent = present
+		return nil
+	})
+
+	g.Go(func() error {
+		present, err := hasDKIM(domain, dkimSelector)
+		if err != nil {
+			addErr(fmt.Errorf("hasDKIM failed: %v", err))
+			return nil
+		}
+		r.DKIMPresent = present
+		return nil
+	})
+
+	g.Go(func() error {
+		srv, err := QuerySRV(domain, srvServices)
+		if err != nil {
+			addErr(fmt.Errorf("QuerySRV failed: %v", err))
+			return nil
+		}
+		r.SRV = srv
+		return nil
+	})
+
+	g.Wait()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// active IPv6 connectivity probing, opt-in via -probe; run after the
+	// above since it needs the fully populated Host6/NS6/MX6/WWW6 lists.
+	if probeEnabled {
+		r.Reach6 = ProbeReachability(&r)
 	}
 
 	// sort all lists
@@ -175,6 +1112,41 @@ func (r *Result) Display() {
 	for _, s := range r.MX6 {
 		fmt.Printf("  %s\n", s)
 	}
+
+	fmt.Printf("SPF permitted networks:\n")
+	for _, s := range r.SPFNetworks {
+		fmt.Printf("  %s\n", s)
+	}
+	fmt.Printf("DMARC present: %v\n", r.DMARCPresent)
+	fmt.Printf("DKIM present: %v\n", r.DKIMPresent)
+
+	fmt.Printf("SRV services:\n")
+	for svc, res := range r.SRV {
+		fmt.Printf("  %s: targets=%v v4=%v v6=%v\n", svc, res.Targets, res.V4, res.V6)
+	}
+
+	if len(r.Reach6) > 0 {
+		fmt.Printf("IPv6 reachability:\n")
+		for key, res := range r.Reach6 {
+			fmt.Printf("  %s: dial=%v tls=%q http=%d rtt=%v\n", key, res.Dial, res.TLS, res.HTTPStatus, res.RTT)
+		}
+	}
+}
+
+// reach6 reports whether at least one probed address under the given
+// category prefix ("www:", "mx:", "ns:", "host:") actually dialed
+// successfully. If probing wasn't run (r.Reach6 is empty), it defaults to
+// true so Rank falls back to its pre-probing, AAAA-presence-only behaviour.
+func reach6(r *Result, prefix string) bool {
+	if len(r.Reach6) == 0 {
+		return true
+	}
+	for key, res := range r.Reach6 {
+		if strings.HasPrefix(key, prefix) && res.Dial {
+			return true
+		}
+	}
+	return false
 }
 
 func Rank(r *Result) string {
@@ -182,23 +1154,201 @@ func Rank(r *Result) string {
 		return "?????"
 	}
 	stars := 0
-	if len(r.Host6) > 0 {
+	if len(r.Host6) > 0 && reach6(r, "host:") {
 		stars += 1
 	}
-	if len(r.MX4) > 0 && len(r.MX6) > 0 {
+	if len(r.MX4) > 0 && len(r.MX6) > 0 && reach6(r, "mx:") {
 		stars += 1
 	}
-	if len(r.WWW4) > 0 && len(r.WWW6) > 0 {
+	if len(r.WWW4) > 0 && len(r.WWW6) > 0 && reach6(r, "www:") {
 		stars += 1
 	}
-	if len(r.NS6) > 0 {
+	if len(r.NS6) > 0 && reach6(r, "ns:") {
 		// NYI: r.DNS6Only so we +2 if NS v6
 		stars += 2
 	}
+	if r.SPFAllIP6 && r.DMARCPresent {
+		// outbound mail path is IPv6-only and DMARC-protected
+		stars += 1
+	}
+	for _, srv := range r.SRV {
+		if len(srv.V6) > 0 {
+			// at least one published service (XMPP federation, LDAP, ...) is v6-ready
+			stars += 1
+			break
+		}
+	}
 
 	return strings.Repeat("*", stars)
 }
 
+// apiResult is the stable, snake_case JSON schema for a Result, used by
+// -format json, the -check batch mode and the /api/q and /api/batch HTTP
+// endpoints. Fields are plain (non-pointer, non-omitempty) so that absent
+// data is marshaled as an explicit null/empty value rather than disappearing.
+type apiResult struct {
+	Domain    string `json:"domain"`
+	Timestamp string `json:"timestamp"` // RFC 3339 (ISO-8601)
+	Resolver  string `json:"resolver"`
+	Rank      string `json:"rank"`
+	Error     string `json:"error"`
+
+	Host4 []string `json:"host_a"`
+	Host6 []string `json:"host_aaaa"`
+	WWW4  []string `json:"www_a"`
+	WWW6  []string `json:"www_aaaa"`
+	NS4   []string `json:"ns_a"`
+	NS6   []string `json:"ns_aaaa"`
+	MX4   []string `json:"mx_a"`
+	MX6   []string `json:"mx_aaaa"`
+
+	SPFNetworks  []string `json:"spf_networks"`
+	SPFHasIP6    bool     `json:"spf_has_ip6"`
+	SPFAllIP6    bool     `json:"spf_all_ip6"`
+	DMARCPresent bool     `json:"dmarc_present"`
+	DKIMPresent  bool     `json:"dkim_present"`
+
+	SRV    map[string]SRVResult `json:"srv"`
+	Reach6 map[string]apiProbe  `json:"reach6"`
+}
+
+// apiProbe is the JSON view of a ProbeResult, with RTT expressed in
+// milliseconds instead of a raw time.Duration.
+type apiProbe struct {
+	Dial       bool    `json:"dial"`
+	TLS        string  `json:"tls"`
+	HTTPStatus int     `json:"http_status"`
+	RTTMillis  float64 `json:"rtt_ms"`
+}
+
+// newAPIResult converts a Result (plus its computed Rank) into the stable
+// API schema, stamping it with the given timestamp.
+func newAPIResult(r *Result, timestamp time.Time) apiResult {
+	a := apiResult{
+		Domain:       r.Domain,
+		Timestamp:    timestamp.Format(time.RFC3339),
+		Resolver:     resolver.Identity(),
+		Rank:         Rank(r),
+		Host4:        r.Host4,
+		Host6:        r.Host6,
+		WWW4:         r.WWW4,
+		WWW6:         r.WWW6,
+		NS4:          r.NS4,
+		NS6:          r.NS6,
+		MX4:          r.MX4,
+		MX6:          r.MX6,
+		SPFNetworks:  r.SPFNetworks,
+		SPFHasIP6:    r.SPFHasIP6,
+		SPFAllIP6:    r.SPFAllIP6,
+		DMARCPresent: r.DMARCPresent,
+		DKIMPresent:  r.DKIMPresent,
+		SRV:          r.SRV,
+	}
+	if r.Reach6 != nil {
+		a.Reach6 = make(map[string]apiProbe, len(r.Reach6))
+		for k, p := range r.Reach6 {
+			a.Reach6[k] = apiProbe{Dial: p.Dial, TLS: p.TLS, HTTPStatus: p.HTTPStatus, RTTMillis: float64(p.RTT.Microseconds()) / 1000}
+		}
+	}
+	return a
+}
+
+// newAPIError builds the apiResult shape for a domain that failed to resolve.
+func newAPIError(domain string, err error, timestamp time.Time) apiResult {
+	return apiResult{
+		Domain:    domain,
+		Timestamp: timestamp.Format(time.RFC3339),
+		Resolver:  resolver.Identity(),
+		Rank:      Rank(nil),
+		Error:     err.Error(),
+	}
+}
+
+// Identity describes the resolver configuration, for the API's "resolver"
+// field: "system" for the host's stub resolver, or the configured upstream
+// servers and transport otherwise.
+func (res *Resolver) Identity() string {
+	if len(res.servers) == 0 {
+		return "system"
+	}
+	return fmt.Sprintf("%s via %s", strings.Join(res.servers, ","), res.netType)
+}
+
+var csvHeader = []string{
+	"domain", "rank", "host_a", "host_aaaa", "www_a", "www_aaaa",
+	"ns_a", "ns_aaaa", "mx_a", "mx_aaaa",
+	"spf_has_ip6", "spf_all_ip6", "dmarc_present", "dkim_present", "error",
+}
+
+// csvRow renders an apiResult as a single CSV row (see csvHeader for the
+// column order), joining multi-valued fields with ";".
+func csvRow(a apiResult) []string {
+	return []string{
+		a.Domain, a.Rank,
+		strings.Join(a.Host4, ";"), strings.Join(a.Host6, ";"),
+		strings.Join(a.WWW4, ";"), strings.Join(a.WWW6, ";"),
+		strings.Join(a.NS4, ";"), strings.Join(a.NS6, ";"),
+		strings.Join(a.MX4, ";"), strings.Join(a.MX6, ";"),
+		strconv.FormatBool(a.SPFHasIP6), strconv.FormatBool(a.SPFAllIP6),
+		strconv.FormatBool(a.DMARCPresent), strconv.FormatBool(a.DKIMPresent),
+		a.Error,
+	}
+}
+
+// outputFormat selects how single-domain and -check batch results are
+// rendered on stdout, configured from the -format flag in main().
+var outputFormat = "text"
+
+// csvHeaderOnce and csvMu make sure exactly one header row is written per
+// run (even with -check's concurrent workers) and that concurrent CSV rows
+// don't interleave on the underlying writer.
+var (
+	csvHeaderOnce sync.Once
+	csvMu         sync.Mutex
+)
+
+// writeResult renders a Result (or the error from querying it) to w
+// according to outputFormat. Each call emits one record in a single write
+// (the first CSV call also emits the header row), so concurrent callers
+// (e.g. -check workers) don't interleave output.
+func writeResult(w *os.File, domain string, r *Result, queryErr error) {
+	now := time.Now()
+	switch outputFormat {
+	case "json":
+		var a apiResult
+		if queryErr != nil {
+			a = newAPIError(domain, queryErr, now)
+		} else {
+			a = newAPIResult(r, now)
+		}
+		b, err := json.Marshal(a)
+		if err != nil {
+			fmt.Fprintf(w, "%s: json marshal error: %v\n", domain, err)
+			return
+		}
+		fmt.Fprintln(w, string(b))
+	case "csv":
+		var a apiResult
+		if queryErr != nil {
+			a = newAPIError(domain, queryErr, now)
+		} else {
+			a = newAPIResult(r, now)
+		}
+		csvMu.Lock()
+		cw := csv.NewWriter(w)
+		csvHeaderOnce.Do(func() { cw.Write(csvHeader) })
+		cw.Write(csvRow(a))
+		cw.Flush()
+		csvMu.Unlock()
+	default: // "text"
+		if queryErr != nil {
+			fmt.Fprintf(w, "%s: error %s\n", domain, queryErr)
+			return
+		}
+		fmt.Fprintf(w, "%s : %s\n", r.Domain, Rank(r))
+	}
+}
+
 func main() {
 
 	addr := flag.String("a", ":3000", "address to listen to. format = [address]:port ")
@@ -207,8 +1357,73 @@ func main() {
 	check := flag.Int("check", 0, "check domain names from stdin (cmd line mode only)")
 	njobs := flag.Int("njobs", 5, "number of jobs for check domains (cmd line mode only, requires -check)")
 
+	var resolverServers resolverList
+	flag.Var(&resolverServers, "resolver", "upstream DNS server host:port to query instead of the system resolver (repeatable, tried in rotation)")
+	preferGo := flag.Bool("prefer-go", false, "force use of Go's built-in DNS resolver instead of the host's stub resolver")
+	netType := flag.String("net", "udp", "network used to reach -resolver servers: udp, tcp, doh or dot")
+
+	hostCacheTTLFlag := flag.Duration("cache-ttl-host", 15*time.Minute, "TTL applied to cached host (A/AAAA) lookups")
+	nsCacheTTLFlag := flag.Duration("cache-ttl-ns", time.Hour, "TTL applied to cached NS lookups")
+	mxCacheTTLFlag := flag.Duration("cache-ttl-mx", 30*time.Minute, "TTL applied to cached MX lookups")
+	txtCacheTTLFlag := flag.Duration("cache-ttl-txt", 5*time.Minute, "TTL applied to cached TXT lookups (SPF/DKIM/DMARC)")
+	cacheSize := flag.Int("cache-size", 10000, "max number of entries kept in the DNS lookup cache")
+	noCache := flag.Bool("no-cache", false, "disable the DNS lookup cache")
+
+	dkimSelectorFlag := flag.String("dkim-selector", "default", "DKIM selector to check for (<selector>._domainkey.<domain>)")
+
+	srvFlag := flag.String("srv", "", "comma-separated service/proto pairs to probe via SRV (default: a curated set of common services)")
+
+	probe := flag.Bool("probe", false, "actively probe discovered IPv6 addresses for real connectivity instead of trusting AAAA presence")
+	probeTimeoutFlag := flag.Duration("probe-timeout", 3*time.Second, "timeout for each -probe dial/handshake/HTTP attempt")
+
+	format := flag.String("format", "text", "output format for single-domain runs and -check: text, json or csv")
+	maxConcurrency := flag.Int("max-concurrency", 10, "max concurrent lookups served by /api/batch")
+
+	lookupConcurrencyFlag := flag.Int("lookup-concurrency", 8, "max concurrent sub-lookups within a single QueryDomain call")
+
 	flag.Parse()
 
+	switch *netType {
+	case "udp", "tcp", "doh", "dot":
+	default:
+		log.Fatalf("invalid -net %q: must be udp, tcp, doh or dot", *netType)
+	}
+	resolver = newResolver(resolverServers, *preferGo, *netType)
+
+	hostCacheTTL = *hostCacheTTLFlag
+	nsCacheTTL = *nsCacheTTLFlag
+	mxCacheTTL = *mxCacheTTLFlag
+	txtCacheTTL = *txtCacheTTLFlag
+	if !*noCache {
+		lookupCache = dnscache.New(*cacheSize)
+	}
+	dkimSelector = *dkimSelectorFlag
+
+	if custom, err := parseSRVServices(*srvFlag); err != nil {
+		log.Fatal(err)
+	} else if custom != nil {
+		srvServices = custom
+	}
+
+	probeEnabled = *probe
+	probeTimeout = *probeTimeoutFlag
+
+	switch *format {
+	case "text", "json", "csv":
+	default:
+		log.Fatalf("invalid -format %q: must be text, json or csv", *format)
+	}
+	outputFormat = *format
+
+	if *lookupConcurrencyFlag <= 0 {
+		log.Fatalf("invalid -lookup-concurrency %d: must be > 0", *lookupConcurrencyFlag)
+	}
+	lookupConcurrency = *lookupConcurrencyFlag
+
+	if *maxConcurrency <= 0 {
+		log.Fatalf("invalid -max-concurrency %d: must be > 0", *maxConcurrency)
+	}
+
 	f, err := fs.Sub(webDir, "web")
 	if err != nil {
 		log.Fatal(err)
@@ -226,20 +1441,16 @@ func main() {
 	}
 
 	if flag.NArg() == 0 {
-		server(*addr, f, reparse)
+		server(*addr, f, reparse, *maxConcurrency)
 		os.Exit(0)
 	}
 
 	for _, s := range flag.Args() {
 		r, err := QueryDomain(s)
-		if err != nil {
-			fmt.Printf("%s: error %s\n", s, err)
-			continue
-		}
-		if *verbose {
+		if err == nil && *verbose {
 			r.Display()
 		}
-		fmt.Printf("%s : %s\n", r.Domain, Rank(r))
+		writeResult(os.Stdout, s, r, err)
 	}
 }
 
@@ -251,7 +1462,7 @@ func parseTpl(fs fs.FS, path string) (*template.Template, error) {
 	return tpl.Lookup("page"), nil
 }
 
-func server(addr string, fs fs.FS, reparse bool) {
+func server(addr string, fs fs.FS, reparse bool, maxConcurrency int) {
 
 	t, err := parseTpl(fs, "templates/*.html")
 	if err != nil {
@@ -285,12 +1496,92 @@ func server(addr string, fs fs.FS, reparse bool) {
 
 	router := httprouter.New()
 	router.GET("/q/:domain", hdl)
+	router.GET("/cache/stats", cacheStatsHdl)
+	router.GET("/api/q/:domain", apiQueryHdl)
+	router.POST("/api/batch", apiBatchHdl(maxConcurrency))
 	router.NotFound = http.FileServer(http.FS(fs))
 
 	fmt.Printf("start listening on %s (ctrl-c to quit)\n", addr)
 	log.Fatal(http.ListenAndServe(addr, router))
 }
 
+// apiQueryHdl serves GET /api/q/:domain: the Result plus its Rank as JSON,
+// for programmatic consumers that don't want to scrape the HTML UI.
+func apiQueryHdl(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	domain := ps.ByName("domain")
+	result, err := QueryDomain(domain)
+	w.Header().Set("Content-Type", "application/json")
+	var a apiResult
+	if err != nil {
+		a = newAPIError(domain, err, time.Now())
+	} else {
+		a = newAPIResult(result, time.Now())
+	}
+	json.NewEncoder(w).Encode(a)
+}
+
+// apiBatchHdl serves POST /api/batch: a JSON array of domains in the
+// request body, streamed back as NDJSON (one apiResult per line) as each
+// domain finishes, using a worker pool bounded by maxConcurrency (the same
+// channel/WaitGroup pattern as scanFile).
+func apiBatchHdl(maxConcurrency int) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var domains []string
+		if err := json.NewDecoder(r.Body).Decode(&domains); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		var mu sync.Mutex
+
+		jobs := make(chan string, maxConcurrency)
+		var wg sync.WaitGroup
+		for i := 0; i < maxConcurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for domain := range jobs {
+					result, err := QueryDomain(domain)
+					var a apiResult
+					if err != nil {
+						a = newAPIError(domain, err, time.Now())
+					} else {
+						a = newAPIResult(result, time.Now())
+					}
+					b, err := json.Marshal(a)
+					if err != nil {
+						continue
+					}
+					mu.Lock()
+					w.Write(b)
+					w.Write([]byte("\n"))
+					if flusher != nil {
+						flusher.Flush()
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, domain := range domains {
+			jobs <- domain
+		}
+		close(jobs)
+		wg.Wait()
+	}
+}
+
+// cacheStatsHdl reports hit/miss counters for the DNS lookup cache.
+func cacheStatsHdl(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if lookupCache == nil {
+		fmt.Fprintf(w, "cache disabled (-no-cache)\n")
+		return
+	}
+	hits, misses := lookupCache.Stats()
+	fmt.Fprintf(w, "entries: %d\nhits: %d\nmisses: %d\n", lookupCache.Len(), hits, misses)
+}
+
 // scan a file,reading domain names, one by line
 // and apply a check based on the "mode" argument value:
 // 4 - only output the domain names that are IPv4 only
@@ -328,8 +1619,37 @@ func scanFile(file *os.File, mode int, numjobs int) {
 // 4 - only output the domain names that are IPv4 only
 // 6 - only output the domain names that are IPv6 only
 // any other value: out #IP entries
+// This selection applies regardless of -format: json/csv output is filtered
+// the same way the default text output is, rather than dumping every domain.
+
+// checkModeMatches reports whether r passes the mode selection documented on
+// checkDom. Mode 1, which the text output also skips, never matches.
+func checkModeMatches(r *Result, mode int) bool {
+	if mode != 4 && mode != 6 && mode != 1 {
+		return true
+	}
+	ip4 := len(r.Host4)+len(r.WWW4) > 0
+	ip6 := len(r.Host6)+len(r.WWW6) > 0
+	switch mode {
+	case 6:
+		return ip6 && !ip4
+	case 4:
+		return !ip6 && ip4
+	default: // mode == 1: no selection is defined for it, so it never matches
+		return false
+	}
+}
+
 func checkDom(dom string, mode int) {
 	r, err := QueryDomain(dom)
+
+	if outputFormat != "text" {
+		if err != nil || checkModeMatches(r, mode) {
+			writeResult(os.Stdout, dom, r, err)
+		}
+		return
+	}
+
 	if err != nil {
 		fmt.Printf("%s, (%s)\n", dom, err)
 		return
@@ -338,15 +1658,7 @@ func checkDom(dom string, mode int) {
 		fmt.Printf("%s, %d, %d, %d, %d\n", r.Domain, len(r.Host4), len(r.WWW4), len(r.Host6), len(r.WWW6))
 		return
 	}
-
-	ip4 := len(r.Host4)+len(r.WWW4) > 0
-	ip6 := len(r.Host6)+len(r.WWW6) > 0
-	if mode == 6 && ip6 && !ip4 {
-		fmt.Printf("%s\n", r.Domain)
-		return
-	}
-	if mode == 4 && !ip6 && ip4 {
+	if checkModeMatches(r, mode) {
 		fmt.Printf("%s\n", r.Domain)
-		return
 	}
 }
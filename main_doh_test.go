@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// rawName encodes name as a plain (uncompressed) RFC 1035 label sequence.
+func rawName(name string) []byte {
+	var buf bytes.Buffer
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// buildMessage assembles a minimal RFC 1035 message: a 12-byte header
+// (qdcount=1, ancount=len(answers), the given rcode), one question for
+// name/qtype, and the given pre-encoded answer records.
+func buildMessage(rcode int, name string, qtype uint16, answers ...[]byte) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 12)
+	header[3] = byte(rcode & 0x0f)
+	binary.BigEndian.PutUint16(header[4:6], 1)
+	binary.BigEndian.PutUint16(header[6:8], uint16(len(answers)))
+	buf.Write(header)
+	buf.Write(rawName(name))
+	var tail [4]byte
+	binary.BigEndian.PutUint16(tail[0:2], qtype)
+	binary.BigEndian.PutUint16(tail[2:4], dnsClassIN)
+	buf.Write(tail[:])
+	for _, a := range answers {
+		buf.Write(a)
+	}
+	return buf.Bytes()
+}
+
+// answerRR builds one answer resource record whose owner name is a
+// compression pointer back to the question name at offset 12.
+func answerRR(typ uint16, rdata []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xc0, 0x0c}) // pointer to offset 12 (the question name)
+	var head [8]byte
+	binary.BigEndian.PutUint16(head[0:2], typ)
+	binary.BigEndian.PutUint16(head[2:4], dnsClassIN)
+	binary.BigEndian.PutUint32(head[4:8], 300) // ttl
+	buf.Write(head[:])
+	var rdlen [2]byte
+	binary.BigEndian.PutUint16(rdlen[:], uint16(len(rdata)))
+	buf.Write(rdlen[:])
+	buf.Write(rdata)
+	return buf.Bytes()
+}
+
+func TestDecodeDNSMessageRcodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		rcode        int
+		wantErr      bool
+		wantNotfound bool
+	}{
+		{"noerror", dnsRcodeNoError, false, false},
+		{"servfail", 2, true, false},
+		{"nxdomain", dnsRcodeNXDomain, true, true},
+		{"refused", 5, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := buildMessage(tt.rcode, "example.com", dnsTypeA)
+			_, err := decodeDNSMessage(msg, "example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeDNSMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := isNotfound(err); got != tt.wantNotfound {
+				t.Errorf("isNotfound(err) = %v, want %v", got, tt.wantNotfound)
+			}
+		})
+	}
+}
+
+func TestDecodeDNSMessageRecords(t *testing.T) {
+	t.Run("A", func(t *testing.T) {
+		rdata := net.IPv4(192, 0, 2, 1).To4()
+		msg := buildMessage(dnsRcodeNoError, "example.com", dnsTypeA, answerRR(dnsTypeA, rdata))
+		rrs, err := decodeDNSMessage(msg, "example.com")
+		if err != nil {
+			t.Fatalf("decodeDNSMessage: %v", err)
+		}
+		if len(rrs) != 1 || rrs[0].Type != dnsTypeA || !bytes.Equal(rrs[0].RData, rdata) {
+			t.Fatalf("got %+v, want one A record with rdata %v", rrs, rdata)
+		}
+	})
+
+	t.Run("AAAA", func(t *testing.T) {
+		rdata := net.ParseIP("2001:db8::1").To16()
+		msg := buildMessage(dnsRcodeNoError, "example.com", dnsTypeAAAA, answerRR(dnsTypeAAAA, rdata))
+		rrs, err := decodeDNSMessage(msg, "example.com")
+		if err != nil {
+			t.Fatalf("decodeDNSMessage: %v", err)
+		}
+		if len(rrs) != 1 || rrs[0].Type != dnsTypeAAAA || !bytes.Equal(rrs[0].RData, rdata) {
+			t.Fatalf("got %+v, want one AAAA record with rdata %v", rrs, rdata)
+		}
+	})
+
+	t.Run("NS", func(t *testing.T) {
+		msg := buildMessage(dnsRcodeNoError, "example.com", dnsTypeNS, answerRR(dnsTypeNS, rawName("ns1.example.com")))
+		rrs, err := decodeDNSMessage(msg, "example.com")
+		if err != nil {
+			t.Fatalf("decodeDNSMessage: %v", err)
+		}
+		if len(rrs) != 1 || rrs[0].Type != dnsTypeNS {
+			t.Fatalf("got %+v, want one NS record", rrs)
+		}
+		host, _, err := decodeDNSName(rrs[0].Msg, rrs[0].RDataOffset)
+		if err != nil {
+			t.Fatalf("decodeDNSName: %v", err)
+		}
+		if host != "ns1.example.com" {
+			t.Errorf("host = %q, want ns1.example.com", host)
+		}
+	})
+
+	t.Run("MX", func(t *testing.T) {
+		var rdata bytes.Buffer
+		var pref [2]byte
+		binary.BigEndian.PutUint16(pref[:], 10)
+		rdata.Write(pref[:])
+		rdata.Write(rawName("mail.example.com"))
+		msg := buildMessage(dnsRcodeNoError, "example.com", dnsTypeMX, answerRR(dnsTypeMX, rdata.Bytes()))
+		rrs, err := decodeDNSMessage(msg, "example.com")
+		if err != nil {
+			t.Fatalf("decodeDNSMessage: %v", err)
+		}
+		if len(rrs) != 1 || rrs[0].Type != dnsTypeMX || len(rrs[0].RData) < 2 {
+			t.Fatalf("got %+v, want one MX record", rrs)
+		}
+		if got := binary.BigEndian.Uint16(rrs[0].RData[:2]); got != 10 {
+			t.Errorf("pref = %d, want 10", got)
+		}
+		host, _, err := decodeDNSName(rrs[0].Msg, rrs[0].RDataOffset+2)
+		if err != nil {
+			t.Fatalf("decodeDNSName: %v", err)
+		}
+		if host != "mail.example.com" {
+			t.Errorf("host = %q, want mail.example.com", host)
+		}
+	})
+
+	t.Run("SRV", func(t *testing.T) {
+		var rdata bytes.Buffer
+		var head [6]byte
+		binary.BigEndian.PutUint16(head[0:2], 10)   // priority
+		binary.BigEndian.PutUint16(head[2:4], 20)   // weight
+		binary.BigEndian.PutUint16(head[4:6], 5222) // port
+		rdata.Write(head[:])
+		rdata.Write(rawName("xmpp.example.com"))
+		msg := buildMessage(dnsRcodeNoError, "_xmpp-server._tcp.example.com", dnsTypeSRV, answerRR(dnsTypeSRV, rdata.Bytes()))
+		rrs, err := decodeDNSMessage(msg, "_xmpp-server._tcp.example.com")
+		if err != nil {
+			t.Fatalf("decodeDNSMessage: %v", err)
+		}
+		if len(rrs) != 1 || rrs[0].Type != dnsTypeSRV || len(rrs[0].RData) < 6 {
+			t.Fatalf("got %+v, want one SRV record", rrs)
+		}
+		if got := binary.BigEndian.Uint16(rrs[0].RData[4:6]); got != 5222 {
+			t.Errorf("port = %d, want 5222", got)
+		}
+		host, _, err := decodeDNSName(rrs[0].Msg, rrs[0].RDataOffset+6)
+		if err != nil {
+			t.Fatalf("decodeDNSName: %v", err)
+		}
+		if host != "xmpp.example.com" {
+			t.Errorf("host = %q, want xmpp.example.com", host)
+		}
+	})
+}
+
+func TestDecodeDNSNameCompressionPointer(t *testing.T) {
+	// "ns1.example.com" at offset 12, then a second name at a later offset
+	// that's just a pointer back to it.
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 12))
+	buf.Write(rawName("ns1.example.com"))
+	ptrOff := buf.Len()
+	buf.Write([]byte{0xc0, 0x0c})
+	data := buf.Bytes()
+
+	name, next, err := decodeDNSName(data, 12)
+	if err != nil {
+		t.Fatalf("decodeDNSName(direct): %v", err)
+	}
+	if name != "ns1.example.com" {
+		t.Errorf("name = %q, want ns1.example.com", name)
+	}
+	if next != ptrOff {
+		t.Errorf("next = %d, want %d (just past the direct encoding)", next, ptrOff)
+	}
+
+	name2, next2, err := decodeDNSName(data, ptrOff)
+	if err != nil {
+		t.Fatalf("decodeDNSName(pointer): %v", err)
+	}
+	if name2 != "ns1.example.com" {
+		t.Errorf("name2 = %q, want ns1.example.com", name2)
+	}
+	if next2 != ptrOff+2 {
+		t.Errorf("next2 = %d, want %d (just past the 2-byte pointer)", next2, ptrOff+2)
+	}
+}
+
+func TestDecodeDNSNamePointerLoop(t *testing.T) {
+	// A pointer at offset 12 that points right back at itself must not
+	// hang; it should be rejected once the bounded pointer-following loop
+	// gives up.
+	data := make([]byte, 14)
+	data[12] = 0xc0
+	data[13] = 0x0c
+
+	if _, _, err := decodeDNSName(data, 12); err == nil {
+		t.Fatal("expected an error for a self-referential compression pointer, got nil")
+	}
+}
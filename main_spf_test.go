@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// withFakeSPFRecords swaps lookupTXTFn for one backed by records (keyed by
+// domain) for the duration of fn, restoring the real lookupTXT afterwards. A
+// domain absent from records behaves like NXDOMAIN, matching what TraverseSPF
+// tolerates from a real resolver.
+func withFakeSPFRecords(t *testing.T, records map[string][]string, fn func()) {
+	t.Helper()
+	orig := lookupTXTFn
+	lookupTXTFn = func(name string) ([]string, error) {
+		if recs, ok := records[name]; ok {
+			return recs, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	defer func() { lookupTXTFn = orig }()
+	fn()
+}
+
+func TestTraverseSPFRejectsTrueCycle(t *testing.T) {
+	records := map[string][]string{
+		"a.example.com": {"v=spf1 include:b.example.com -all"},
+		"b.example.com": {"v=spf1 include:a.example.com -all"},
+	}
+	withFakeSPFRecords(t, records, func() {
+		_, err := TraverseSPF("a.example.com")
+		if err == nil {
+			t.Fatal("expected an error for an SPF include cycle, got nil")
+		}
+		if !strings.Contains(err.Error(), "loop") {
+			t.Errorf("error = %q, want it to mention a loop", err)
+		}
+	})
+}
+
+func TestTraverseSPFAllowsDiamond(t *testing.T) {
+	records := map[string][]string{
+		"a.example.com": {"v=spf1 include:b.example.com include:c.example.com -all"},
+		"b.example.com": {"v=spf1 include:d.example.com -all"},
+		"c.example.com": {"v=spf1 include:d.example.com -all"},
+		"d.example.com": {"v=spf1 ip4:198.51.100.0/24 -all"},
+	}
+	withFakeSPFRecords(t, records, func() {
+		networks, err := TraverseSPF("a.example.com")
+		if err != nil {
+			t.Fatalf("TraverseSPF: %v", err)
+		}
+		count := 0
+		for _, n := range networks {
+			if n == "198.51.100.0/24" {
+				count++
+			}
+		}
+		// d.example.com is legitimately reached via both b and c, so its
+		// network should show up once per path, not be rejected as a loop.
+		if count != 2 {
+			t.Errorf("got %v, want 198.51.100.0/24 twice (once via b, once via c)", networks)
+		}
+	})
+}
+
+func TestTraverseSPFEnforcesLookupLimit(t *testing.T) {
+	// d0 -> d1 -> ... -> d10, each consuming one lookup via "include:"; the
+	// 11th include (d10 -> d11) must trip the RFC 7208 limit of 10.
+	records := make(map[string][]string)
+	for i := 0; i <= 10; i++ {
+		records[fmt.Sprintf("d%d.example.com", i)] = []string{
+			fmt.Sprintf("v=spf1 include:d%d.example.com -all", i+1),
+		}
+	}
+	withFakeSPFRecords(t, records, func() {
+		_, err := TraverseSPF("d0.example.com")
+		if err == nil {
+			t.Fatal("expected the SPF lookup limit to be exceeded, got nil error")
+		}
+		if !strings.Contains(err.Error(), "lookup limit") {
+			t.Errorf("error = %q, want it to mention the lookup limit", err)
+		}
+	})
+}
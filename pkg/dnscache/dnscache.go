@@ -0,0 +1,145 @@
+// Package dnscache provides a small in-process, size-bounded, TTL-bounded
+// LRU cache for DNS lookup results. It is used to avoid re-resolving the
+// same NS/MX/host records over and over when scanning large lists of
+// domains that share parents or mail providers.
+package dnscache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// entry is the value stored in the LRU's linked list.
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// Cache is a concurrency-safe, size-bounded LRU cache with a per-entry
+// expiry time.
+type Cache struct {
+	mu      sync.Mutex
+	ll      *list.List
+	items   map[string]*list.Element
+	maxSize int
+
+	// loads coalesces concurrent GetOrLoad misses on the same key so that,
+	// e.g., N workers resolving domains that share a cold NS/MX record only
+	// trigger a single load() call between them instead of a duplicate-query
+	// storm.
+	loads singleflight.Group
+
+	hits, misses uint64
+}
+
+// New creates a Cache holding at most maxSize entries. A maxSize of 0 means
+// unbounded.
+func New(maxSize int) *Cache {
+	return &Cache{
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+		maxSize: maxSize,
+	}
+}
+
+// Get returns the cached value for key, if present and not expired.
+func (c *Cache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.removeElement(el)
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	atomic.AddUint64(&c.hits, 1)
+	return e.value, true
+}
+
+// Set stores value for key with the given ttl, evicting the least recently
+// used entry if the cache is now over its size bound.
+func (c *Cache) Set(key string, value any, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		e.expires = time.Now().Add(ttl)
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&entry{key: key, value: value, expires: time.Now().Add(ttl)})
+	c.items[key] = el
+	if c.maxSize > 0 && c.ll.Len() > c.maxSize {
+		c.removeOldest()
+	}
+}
+
+func (c *Cache) removeOldest() {
+	if el := c.ll.Back(); el != nil {
+		c.removeElement(el)
+	}
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
+
+// Stats returns the cumulative hit/miss counters since the cache was created.
+func (c *Cache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+// Len returns the number of entries currently held (including any not yet
+// reaped past their expiry).
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// GetOrLoad returns the cached value for key if present, otherwise calls
+// load, caches its result for ttl and returns it. load errors are not
+// cached, so a failing lookup is retried next time. Concurrent misses on the
+// same key are coalesced: only one caller actually runs load, and the rest
+// wait for and share its result.
+func GetOrLoad[T any](c *Cache, key string, ttl time.Duration, load func() (T, error)) (T, error) {
+	if c != nil {
+		if v, ok := c.Get(key); ok {
+			return v.(T), nil
+		}
+	}
+
+	if c == nil {
+		return load()
+	}
+
+	v, err, _ := c.loads.Do(key, func() (any, error) {
+		v, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.Set(key, v, ttl)
+		return v, nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v.(T), nil
+}
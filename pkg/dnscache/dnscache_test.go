@@ -0,0 +1,107 @@
+package dnscache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLRUEvictionOrder checks that, once the cache is over its size bound,
+// the least recently used entry is the one evicted, and that Get (which
+// promotes an entry to the front) changes what counts as "least recently
+// used".
+func TestLRUEvictionOrder(t *testing.T) {
+	c := New(2)
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a to be present")
+	}
+
+	c.Set("c", 3, time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected b to have been evicted as least recently used")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("expected a to still be present")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Errorf("expected c to be present")
+	}
+	if got := c.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+}
+
+// TestExpiry checks that an entry past its TTL is treated as a miss and
+// removed, rather than being returned stale.
+func TestExpiry(t *testing.T) {
+	c := New(0)
+
+	c.Set("k", "v", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	if _, ok := c.Get("k"); ok {
+		t.Errorf("expected expired entry to be a miss")
+	}
+	if got := c.Len(); got != 0 {
+		t.Errorf("Len() = %d, want 0 after expiry reaps the entry", got)
+	}
+
+	hits, misses := c.Stats()
+	if hits != 0 || misses != 1 {
+		t.Errorf("Stats() = (%d, %d), want (0, 1)", hits, misses)
+	}
+}
+
+// TestGetOrLoadCoalescesConcurrentMisses checks that N concurrent
+// GetOrLoad calls on the same cold key trigger exactly one load, with every
+// caller receiving its result.
+func TestGetOrLoadCoalescesConcurrentMisses(t *testing.T) {
+	c := New(0)
+
+	var loads int32
+	var ready = make(chan struct{})
+	load := func() (string, error) {
+		atomic.AddInt32(&loads, 1)
+		<-ready // hold the in-flight load open until every caller has joined it
+		return "value", nil
+	}
+
+	const n = 20
+	var started sync.WaitGroup
+	var wg sync.WaitGroup
+	results := make([]string, n)
+	started.Add(n)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			started.Done()
+			v, err := GetOrLoad(c, "key", time.Minute, load)
+			if err != nil {
+				t.Errorf("GetOrLoad: %v", err)
+			}
+			results[i] = v
+		}(i)
+	}
+
+	started.Wait()
+	time.Sleep(20 * time.Millisecond) // let every goroutine reach the singleflight call
+	close(ready)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&loads); got != 1 {
+		t.Errorf("load() called %d times, want 1", got)
+	}
+	for i, v := range results {
+		if v != "value" {
+			t.Errorf("results[%d] = %q, want %q", i, v, "value")
+		}
+	}
+}